@@ -0,0 +1,69 @@
+package recolour
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// Apply reconstructs the recoloured sprite on the CPU: it reads a reference
+// sprite produced by GenerateFromImage (FormatRGBA, the default), decodes
+// the palette index from the red and blue channels, looks the colour up in
+// palette, and writes an NRGBA image using the reference pixel's alpha.
+//
+// This gives a reference implementation of what the shader does, useful
+// for previews, unit tests and headless pipelines that don't have a GPU
+// available. Generate followed by Apply should reproduce the original
+// image, within the fidelity of the palette index (i.e. colours that were
+// merged because they render identically will come back identical, not
+// bit-for-bit matching the source if it had, say, differing premultiplied
+// alpha for the same visible colour).
+func Apply(refImage image.Image, palette []color.RGBA) (image.Image, error) {
+	bounds := refImage.Bounds()
+	out := image.NewNRGBA(bounds)
+
+	// The reference sprite stores the raw index bytes unpremultiplied in
+	// R/B, independent of A. Reading it back via color.Color.RGBA() would
+	// premultiply those bytes by alpha and corrupt the index for any
+	// partially-transparent pixel, so if refImage isn't already *image.NRGBA
+	// (the type GenerateFromImage/png.Decode hand back for this format)
+	// we go through color.NRGBAModel directly instead.
+	nrgba, ok := refImage.(*image.NRGBA)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var inpix color.NRGBA
+			if ok {
+				inpix = nrgba.NRGBAAt(x, y)
+			} else {
+				inpix = color.NRGBAModel.Convert(refImage.At(x, y)).(color.NRGBA)
+			}
+			idx := uint16(inpix.R) | uint16(inpix.B)<<8
+
+			var pixel color.RGBA
+			if int(idx) < len(palette) {
+				pixel = palette[idx]
+			}
+			out.SetNRGBA(x, y, color.NRGBA{pixel.R, pixel.G, pixel.B, inpix.A})
+		}
+	}
+
+	return out, nil
+}
+
+// ApplyFromFile is the file-path variant of Apply: it decodes the
+// reference sprite PNG at refImagePath before applying palette to it.
+func ApplyFromFile(refImagePath string, palette []color.RGBA) (image.Image, error) {
+	f, err := os.OpenFile(refImagePath, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	refImage, err := png.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return Apply(refImage, palette)
+}
@@ -0,0 +1,76 @@
+package recolour
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// TestApplyRoundTrip checks the guarantee Apply's doc comment makes:
+// Generate followed by Apply reproduces the original image, within the
+// fidelity of the palette index.
+func TestApplyRoundTrip(t *testing.T) {
+	palette := []color.NRGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {10, 20, 30, 255},
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetNRGBA(x, y, palette[(x+y)%len(palette)])
+		}
+	}
+
+	dir := t.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+	pal, err := GenerateFromImage(src, refPath, palPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ApplyFromFile(refPath, pal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := src.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := colourTo8BitRGBA(src.At(x, y))
+			got := colourTo8BitRGBA(out.At(x, y))
+			if want != got {
+				t.Fatalf("pixel (%d,%d): want %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+// TestApplyPreservesRealAlpha checks that Apply reads the reference
+// sprite's actual per-pixel alpha rather than assuming every pixel is
+// opaque, the same case buildRGBAReference's SetNRGBA fix protects.
+func TestApplyPreservesRealAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{10, 20, 30, 128})
+	src.SetNRGBA(1, 0, color.NRGBA{10, 20, 30, 255})
+
+	dir := t.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+	pal, err := GenerateFromImage(src, refPath, palPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ApplyFromFile(refPath, pal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, a := out.At(0, 0).RGBA(); uint8(a>>8) != 128 {
+		t.Fatalf("pixel (0,0): want alpha 128, got %d", uint8(a>>8))
+	}
+	if _, _, _, a := out.At(1, 0).RGBA(); uint8(a>>8) != 255 {
+		t.Fatalf("pixel (1,0): want alpha 255, got %d", uint8(a>>8))
+	}
+}
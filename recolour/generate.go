@@ -6,7 +6,6 @@ import (
 	"image/color"
 	"math"
 	"os"
-	"sort"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
 	// This causes the codecs to be loaded
@@ -29,6 +28,10 @@ type UniqueColour struct {
 	H, S, V float64
 	// Store an index so that references in map know final position in list
 	Index uint16
+	// Count is how many source pixels this colour was seen at. Only
+	// maintained by the scan functions that quantization needs it for
+	// (weighting bucket means); zero elsewhere.
+	Count int
 }
 
 type UniqueColourList []*UniqueColour
@@ -75,9 +78,51 @@ func colourTo8BitPaletteRGBA(c color.Color) color.RGBA {
 	return cout
 }
 
+// ReferenceFormat selects how the reference sprite (the file holding the
+// per-pixel palette indices) is encoded.
+type ReferenceFormat int
+
+const (
+	// FormatRGBA encodes the palette index across the red/blue channels of a
+	// 32-bit RGBA PNG, preserving per-pixel alpha in the alpha channel. This
+	// is the default, and is what shaders expect to sample today.
+	FormatRGBA ReferenceFormat = iota
+	// FormatPaletted writes the reference sprite as an actual image.Paletted
+	// PNG: PLTE holds one entry per unique colour (HSV-sorted), with tRNS
+	// recording per-entry alpha. The PNG encoder then picks the smallest
+	// bit depth (1/2/4/8) that fits len(colourList), giving a far smaller
+	// file than the RGBA path at the cost of shader-side support for
+	// indexed textures.
+	FormatPaletted
+)
+
+// GenerateOptions controls optional behaviour of Generate/GenerateFromImage.
+type GenerateOptions struct {
+	// ReferenceFormat selects the encoding of the reference sprite file.
+	// Defaults to FormatRGBA when a zero-value GenerateOptions is used.
+	ReferenceFormat ReferenceFormat
+	// SortMode selects how palette entries (and therefore their encoded
+	// indices) are ordered. Defaults to SortHSV when a zero-value
+	// GenerateOptions is used.
+	SortMode SortMode
+	// MaxColors, when non-zero, opts in to quantizing the palette down to
+	// at most this many colours (must be <= 65536) instead of returning an
+	// error when the sprite has more unique colours than that. Quantizing
+	// is lossy: colours are merged using median-cut in CIE L*a*b* space, so
+	// only set this for photographic or gradient-heavy art where some loss
+	// is acceptable; leave it zero for 8-bit-style sprites where an exact
+	// palette matters.
+	MaxColors int
+}
+
 // Generate reads an input sprite texture and generates a reference sprite file,
 // and a base lookup texture and / or parameter list
 func Generate(imagePath, outImagePath, outPaletteTexture string) ([]color.RGBA, error) {
+	return GenerateWithOptions(imagePath, outImagePath, outPaletteTexture, GenerateOptions{})
+}
+
+// GenerateWithOptions is Generate with explicit control over GenerateOptions.
+func GenerateWithOptions(imagePath, outImagePath, outPaletteTexture string, opts GenerateOptions) ([]color.RGBA, error) {
 
 	f, err := os.OpenFile(imagePath, os.O_RDONLY, 0644)
 	if err != nil {
@@ -90,102 +135,204 @@ func Generate(imagePath, outImagePath, outPaletteTexture string) ([]color.RGBA,
 		return nil, err
 	}
 
-	return GenerateFromImage(img, outImagePath, outPaletteTexture)
+	return GenerateFromImageWithOptions(img, outImagePath, outPaletteTexture, opts)
 }
 
 // GenerateFromImage reads an image and generates a reference sprite file,
 // and a base lookup texture and / or parameter list
 func GenerateFromImage(img image.Image, outImagePath, outPaletteTexture string) ([]color.RGBA, error) {
+	return GenerateFromImageWithOptions(img, outImagePath, outPaletteTexture, GenerateOptions{})
+}
+
+// GenerateFromImageWithOptions is GenerateFromImage with explicit control
+// over GenerateOptions, e.g. to select ReferenceFormat.
+func GenerateFromImageWithOptions(img image.Image, outImagePath, outPaletteTexture string, opts GenerateOptions) ([]color.RGBA, error) {
 	bounds := img.Bounds()
 	// Record of what colours are present
 	colourMap := make(map[color.RGBA]*UniqueColour)
+	scanUniqueColours(img, bounds, colourMap)
+
+	colourMap, err := capOrQuantizeColours(colourMap, opts.MaxColors)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-order the colours (HSV by default, or perceptually via SortMode)
+	colourList := buildSortedColourList(colourMap, opts.SortMode)
+
+	// Now generate the sprite output. paletteColourList tracks whichever
+	// colourList actually ends up embedded in outSprite, so the returned
+	// palette (and outPaletteTexture) always matches the file written.
+	paletteColourList := colourList
+	var outSprite image.Image
+	switch opts.ReferenceFormat {
+	case FormatPaletted:
+		// Unlike colourMap above, palette entries here must keep their real
+		// per-pixel alpha (via tRNS) rather than being forced to A=255, so
+		// colours that only differ by alpha need their own entries.
+		alphaColourMap, _, err := buildAlphaAwareColourList(img, bounds, opts.SortMode)
+		if err != nil {
+			return nil, err
+		}
+		alphaColourMap, err = capOrQuantizeAlphaColours(alphaColourMap, opts.MaxColors)
+		if err != nil {
+			return nil, err
+		}
+		alphaColourList := buildSortedColourList(alphaColourMap, opts.SortMode)
+		outSprite = buildPalettedReference(img, bounds, alphaColourMap, alphaColourList)
+		paletteColourList = alphaColourList
+	default:
+		outSprite = buildRGBAReference(img, bounds, colourMap)
+	}
+	of, err := os.OpenFile(outImagePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = png.Encode(of, outSprite)
+	of.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Now write palette texture & build return
+	return writePaletteTexture(paletteColourList, outPaletteTexture)
+}
+
+// buildRGBAReference is the original reference-sprite encoding: the palette
+// index is split across the red/blue channels of a 32-bit RGBA image, with
+// the source pixel's alpha preserved in the alpha channel.
+func buildRGBAReference(img image.Image, bounds image.Rectangle, colourMap map[color.RGBA]*UniqueColour) *image.NRGBA {
+	outSprite := image.NewNRGBA(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			inpix := colourTo8BitPaletteRGBA(img.At(x, y))
+
+			// Should never fail but just don't write pixel if it does
+			if col, ok := colourMap[inpix]; ok {
+				// Red channel = low byte of colour index
+				red := uint8(col.Index & 0x00FF)
+				// Blue channel = high byte of colour index
+				blue := uint8(col.Index >> 8)
+				// Green channel = unused for now
+				// Alpha: inpix is forced opaque (it's also the colourMap
+				// lookup key), so take the real alpha straight from source
+				alpha := colourTo8BitRGBA(img.At(x, y)).A
+				// SetNRGBA, not Set: color.RGBA is alpha-premultiplied, so
+				// outSprite.Set(x, y, color.RGBA{red, blue, 0, alpha}) would
+				// silently un-premultiply these index bytes against alpha,
+				// corrupting the index for every pixel with alpha != 255.
+				outSprite.SetNRGBA(x, y, color.NRGBA{red, blue, 0, alpha})
+			}
+		}
+	}
+	return outSprite
+}
+
+// scanUniqueColours walks every pixel of img within bounds and records one
+// UniqueColour per distinct opaque-forced colour (see
+// colourTo8BitPaletteRGBA) into colourMap, leaving existing entries alone.
+// Callers can invoke this once per image to merge colours across several
+// images into a single map (e.g. the frames of a GIF).
+func scanUniqueColours(img image.Image, bounds image.Rectangle, colourMap map[color.RGBA]*UniqueColour) {
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			// Go colours are alpha-premultiplied and uint32's with 65535 range: weird
 			// We want NON alpha premultiplied by default (internally could be premultiplied)
 			p := colourTo8BitPaletteRGBA(img.At(x, y))
 
-			if _, ok := colourMap[p]; !ok {
-				cfcol := colorful.Color{float64(p.R) / 255.0, float64(p.G) / 255.0, float64(p.B) / 255.0}
+			if c, ok := colourMap[p]; ok {
+				c.Count++
+			} else {
+				cfcol := colorful.Color{R: float64(p.R) / 255.0, G: float64(p.G) / 255.0, B: float64(p.B) / 255.0}
 				h, s, v := cfcol.Hsv()
-				col := &UniqueColour{p, h, s, v, 0}
-				colourMap[p] = col
+				colourMap[p] = &UniqueColour{RGBA: p, H: h, S: s, V: v, Count: 1}
 			}
 		}
 	}
+}
 
-	if len(colourMap) > 65536 {
-		return nil, fmt.Errorf("Sorry, sprite contains too many colours")
-	}
-
-	// Re-order the colours by HSV so easier to edit
+// buildSortedColourList assigns indices to every UniqueColour in colourMap
+// and orders them according to mode, so that colourList[i].Index == i.
+//
+// colourMap values are deduplicated by pointer identity rather than just
+// collected, since after quantizeColours several keys can share the same
+// representative UniqueColour.
+func buildSortedColourList(colourMap map[color.RGBA]*UniqueColour, mode SortMode) UniqueColourList {
+	seen := make(map[*UniqueColour]bool, len(colourMap))
 	colourList := make(UniqueColourList, 0, len(colourMap))
-	nextIndex := uint16(0)
 	for _, c := range colourMap {
-		c.Index = nextIndex
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
 		colourList = append(colourList, c)
-		nextIndex++
 	}
-	// Sort, the swap function will swap indexes
-	sort.Sort(colourList)
+	return sortColourList(colourList, mode)
+}
 
-	// Now generate the sprite output
-	outSprite := image.NewNRGBA(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y))
+// buildAlphaAwareColourList is like the colourMap/colourList scan in
+// GenerateFromImageWithOptions, except it keys on the full RGBA colour
+// (alpha included) so that pixels differing only in alpha get distinct
+// palette entries, and orders the result the same way.
+func buildAlphaAwareColourList(img image.Image, bounds image.Rectangle, mode SortMode) (map[color.RGBA]*UniqueColour, UniqueColourList, error) {
+	colourMap := make(map[color.RGBA]*UniqueColour)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			inpix := colourTo8BitPaletteRGBA(img.At(x, y))
+			p := colourTo8BitRGBA(img.At(x, y))
 
-			// Should never fail but just don't write pixel if it does
-			if col, ok := colourMap[inpix]; ok {
-				// Red channel = colour index U
-				red := uint8(col.Index & 0x0000FFFF)
-				// Blue channel = colour index V
-				blue := uint8(col.Index >> 16)
-				// Green channel = unused for now
-				outSprite.Set(x, y, color.RGBA{red, blue, 0, inpix.A})
+			if c, ok := colourMap[p]; ok {
+				c.Count++
+			} else {
+				// p's R/G/B came straight out of colourTo8BitRGBA's
+				// alpha-premultiplied RGBA(), so store the un-premultiplied
+				// colour instead: UniqueColour.RGBA is what this function
+				// (via writePaletteTexture) hands back to callers, and it
+				// must match the straight colour actually placed in the
+				// reference sprite's PLTE/tRNS, not a copy scaled down by
+				// its own alpha.
+				straight := color.NRGBAModel.Convert(p).(color.NRGBA)
+				cfcol := colorful.Color{R: float64(straight.R) / 255.0, G: float64(straight.G) / 255.0, B: float64(straight.B) / 255.0}
+				h, s, v := cfcol.Hsv()
+				colourMap[p] = &UniqueColour{RGBA: color.RGBA(straight), H: h, S: s, V: v, Count: 1}
 			}
 		}
 	}
-	of, err := os.OpenFile(outImagePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return nil, err
+
+	if len(colourMap) > 65536 {
+		return nil, nil, fmt.Errorf("Sorry, sprite contains too many colours")
 	}
-	err = png.Encode(of, outSprite)
-	of.Close()
-	if err != nil {
-		return nil, err
+
+	return colourMap, buildSortedColourList(colourMap, mode), nil
+}
+
+// buildPalettedReference writes the reference sprite as an image.Paletted:
+// one PLTE entry per unique colour (already HSV-sorted in colourList, so
+// palette order matches UniqueColour.Index), with per-entry alpha coming
+// through as a tRNS chunk. png.Encode picks the smallest bit depth that
+// fits len(colourList) automatically.
+func buildPalettedReference(img image.Image, bounds image.Rectangle, colourMap map[color.RGBA]*UniqueColour, colourList UniqueColourList) *image.Paletted {
+	pal := make(color.Palette, len(colourList))
+	for _, c := range colourList {
+		// c.RGBA already holds the straight (non-premultiplied) colour.
+		// png's PLTE/tRNS writer unconditionally un-premultiplies whatever
+		// it's handed via color.NRGBAModel.Convert, which is a no-op for an
+		// already-NRGBA value but would darken a color.RGBA one a second
+		// time, so box it as NRGBA here rather than assigning c.RGBA directly.
+		pal[c.Index] = color.NRGBA(c.RGBA)
 	}
 
-	// Now write palette texture & build return
-	palette := make([]color.RGBA, 0, len(colourList))
-	if len(outPaletteTexture) > 0 {
-		width, height := getPaletteImageDimensions(len(colourList))
-		outPalette := image.NewRGBA(image.Rect(0, 0, width, height))
-		x := 0
-		y := 0
-		for n := 0; n < len(colourList); n++ {
-			outPalette.SetRGBA(x, y, colourList[n].RGBA)
-			palette = append(palette, colourList[n].RGBA)
-			x++
-			if x == width {
-				x = 0
-				y++
-			}
-		}
+	outSprite := image.NewPaletted(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y), pal)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			inpix := colourTo8BitRGBA(img.At(x, y))
 
-		opf, err := os.OpenFile(outPaletteTexture, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-		if err != nil {
-			return nil, err
-		}
-		err = png.Encode(opf, outPalette)
-		opf.Close()
-		if err != nil {
-			return nil, err
+			// Should never fail but just don't write pixel if it does
+			if col, ok := colourMap[inpix]; ok {
+				outSprite.SetColorIndex(x, y, uint8(col.Index))
+			}
 		}
-
 	}
-
-	return palette, nil
+	return outSprite
 }
 
 func nextPowerOfTwo(v int) int {
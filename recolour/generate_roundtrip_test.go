@@ -0,0 +1,149 @@
+package recolour
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerateFromImageWithOptionsFormatPalettedRoundTrip checks that
+// FormatPaletted's returned []color.RGBA matches the PLTE/tRNS actually
+// embedded in the reference sprite, and that the sprite decodes back to the
+// original per-pixel colours (including partial alpha).
+func TestGenerateFromImageWithOptionsFormatPalettedRoundTrip(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 128})
+	src.SetNRGBA(0, 1, color.NRGBA{0, 0, 255, 64})
+	src.SetNRGBA(1, 1, color.NRGBA{255, 0, 0, 255})
+
+	dir := t.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+	pal, err := GenerateFromImageWithOptions(src, refPath, palPath, GenerateOptions{ReferenceFormat: FormatPaletted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(refPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := decoded.(*image.Paletted)
+	if !ok {
+		t.Fatalf("expected FormatPaletted to write an *image.Paletted PNG, got %T", decoded)
+	}
+
+	if len(pal) != len(p.Palette) {
+		t.Fatalf("returned palette has %d entries, PNG palette has %d", len(pal), len(p.Palette))
+	}
+	for i, c := range pal {
+		// pal's color.RGBA fields already hold the straight colour (see
+		// buildAlphaAwareColourList), so re-box as color.NRGBA directly
+		// rather than through NRGBAModel.Convert, which would treat the
+		// RGBA type as premultiplied and introduce its own rounding.
+		want := p.Palette[i]
+		got := color.NRGBA(c)
+		if want != got {
+			t.Fatalf("palette entry %d: returned %v, PNG PLTE/tRNS has %v", i, got, want)
+		}
+	}
+
+	bounds := src.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := color.NRGBAModel.Convert(src.At(x, y))
+			got := color.NRGBAModel.Convert(p.At(x, y))
+			if want != got {
+				t.Fatalf("pixel (%d,%d): want %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+// TestGenerateFromImageWithOptionsSortPerceptualRoundTrip checks that
+// SortPerceptual reorders the palette (rather than leaving SortHSV's
+// indices in place) while the reference sprite's indices still resolve, via
+// Apply, back to the original image.
+func TestGenerateFromImageWithOptionsSortPerceptualRoundTrip(t *testing.T) {
+	palette := []color.NRGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {10, 20, 30, 255}, {200, 200, 10, 255},
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			src.SetNRGBA(x, y, palette[(x+y)%len(palette)])
+		}
+	}
+
+	dir := t.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+	pal, err := GenerateFromImageWithOptions(src, refPath, palPath, GenerateOptions{SortMode: SortPerceptual})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ApplyFromFile(refPath, pal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := src.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := colourTo8BitRGBA(src.At(x, y))
+			got := colourTo8BitRGBA(out.At(x, y))
+			if want != got {
+				t.Fatalf("pixel (%d,%d): want %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+// TestGenerateFromImageStreamingRoundTrip checks that the streaming path's
+// returned palette, fed back through Apply, reproduces the original image --
+// the same guarantee TestApplyRoundTrip checks for GenerateFromImage.
+func TestGenerateFromImageStreamingRoundTrip(t *testing.T) {
+	palette := []color.NRGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 128}, {0, 0, 255, 64}, {10, 20, 30, 255},
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetNRGBA(x, y, palette[(x+y)%len(palette)])
+		}
+	}
+
+	dir := t.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+	pal, err := GenerateFromImageStreaming(src, refPath, palPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ApplyFromFile(refPath, pal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := src.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := colourTo8BitRGBA(src.At(x, y))
+			got := colourTo8BitRGBA(out.At(x, y))
+			if want != got {
+				t.Fatalf("pixel (%d,%d): want %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
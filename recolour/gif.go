@@ -0,0 +1,207 @@
+package recolour
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"strings"
+)
+
+// GenerateFromGIF reads a multi-frame animated GIF and builds a single
+// palette shared across every frame, alongside a reference image for each
+// frame encoded against that shared palette.
+//
+// Frames are disposal-composited onto a running canvas the same way a GIF
+// player would (respecting gif.DisposalBackground and gif.DisposalPrevious)
+// before their colours are scanned, so the palette reflects what is
+// actually visible on screen rather than each frame's raw, possibly
+// partial, pixel data.
+//
+// When outImagePath ends in ".gif" the composited frames are re-emitted as
+// a new animated GIF sharing one colour table; this requires the palette
+// to fit within 256 colours, the hard limit of the GIF format. Otherwise
+// outImagePath is treated as a fmt.Sprintf pattern (e.g. "frame_%03d.png")
+// and one frame is written per %d using the same red/blue channel index
+// encoding as GenerateFromImage.
+//
+// opts.SortMode controls palette ordering the same way it does for
+// GenerateFromImageWithOptions; opts.ReferenceFormat is ignored, since a
+// GIF reference is inherently paletted.
+func GenerateFromGIF(g *gif.GIF, outImagePath, outPaletteTexture string, opts GenerateOptions) ([]color.RGBA, error) {
+	frames, bounds, err := compositeGIFFrames(g)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record of what colours are present, merged across every frame
+	colourMap := make(map[color.RGBA]*UniqueColour)
+	for _, frame := range frames {
+		scanUniqueColours(frame, bounds, colourMap)
+	}
+
+	if len(colourMap) > 65536 {
+		return nil, fmt.Errorf("Sorry, sprite contains too many colours")
+	}
+
+	// Re-order the colours (HSV by default, or perceptually via SortMode)
+	colourList := buildSortedColourList(colourMap, opts.SortMode)
+
+	if strings.HasSuffix(outImagePath, ".gif") {
+		if len(colourList) > 256 {
+			return nil, fmt.Errorf("Sorry, animation contains too many colours for a shared GIF palette (%d, max 256)", len(colourList))
+		}
+		if err := writeGIFReference(g, frames, bounds, colourMap, colourList, outImagePath); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := writePNGSequenceReference(frames, bounds, colourMap, outImagePath); err != nil {
+			return nil, err
+		}
+	}
+
+	return writePaletteTexture(colourList, outPaletteTexture)
+}
+
+// compositeGIFFrames walks g.Image in order, disposal-compositing each
+// frame onto a running canvas sized to the logical screen, and returns one
+// fully-composited snapshot per frame plus the canvas bounds.
+func compositeGIFFrames(g *gif.GIF) ([]*image.NRGBA, image.Rectangle, error) {
+	if len(g.Image) == 0 {
+		return nil, image.Rectangle{}, fmt.Errorf("GIF has no frames")
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewNRGBA(bounds)
+
+	frames := make([]*image.NRGBA, 0, len(g.Image))
+	var previous *image.NRGBA
+	for i, src := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, bounds.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, src.Bounds(), src, src.Bounds().Min, draw.Over)
+
+		snapshot := image.NewNRGBA(bounds)
+		draw.Draw(snapshot, bounds, canvas, bounds.Min, draw.Src)
+		frames = append(frames, snapshot)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, src.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			draw.Draw(canvas, bounds, previous, bounds.Min, draw.Src)
+		}
+	}
+
+	return frames, bounds, nil
+}
+
+// writeGIFReference re-emits every composited frame against the shared
+// palette as a single animated GIF.
+func writeGIFReference(g *gif.GIF, frames []*image.NRGBA, bounds image.Rectangle, colourMap map[color.RGBA]*UniqueColour, colourList UniqueColourList, outImagePath string) error {
+	pal := make(color.Palette, len(colourList))
+	for _, c := range colourList {
+		pal[c.Index] = c.RGBA
+	}
+
+	out := &gif.GIF{
+		Image:           make([]*image.Paletted, len(frames)),
+		Delay:           append([]int(nil), g.Delay...),
+		Disposal:        append([]byte(nil), g.Disposal...),
+		Config:          g.Config,
+		BackgroundIndex: g.BackgroundIndex,
+	}
+	for i, frame := range frames {
+		paletted := image.NewPaletted(bounds, pal)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				inpix := colourTo8BitPaletteRGBA(frame.At(x, y))
+				if col, ok := colourMap[inpix]; ok {
+					paletted.SetColorIndex(x, y, uint8(col.Index))
+				}
+			}
+		}
+		out.Image[i] = paletted
+	}
+
+	of, err := os.OpenFile(outImagePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer of.Close()
+	return gif.EncodeAll(of, out)
+}
+
+// writePNGSequenceReference writes one RGBA reference PNG per frame, named
+// by the fmt.Sprintf pattern outImagePathPattern, using the same red/blue
+// channel index encoding as GenerateFromImage.
+func writePNGSequenceReference(frames []*image.NRGBA, bounds image.Rectangle, colourMap map[color.RGBA]*UniqueColour, outImagePathPattern string) error {
+	for i, frame := range frames {
+		outSprite := buildRGBAReference(frame, bounds, colourMap)
+
+		of, err := os.OpenFile(fmt.Sprintf(outImagePathPattern, i), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		err = png.Encode(of, outSprite)
+		of.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePaletteTexture writes the shared palette out as a lookup texture,
+// the same layout GenerateFromImage uses, and builds the []color.RGBA
+// return value.
+func writePaletteTexture(colourList UniqueColourList, outPaletteTexture string) ([]color.RGBA, error) {
+	palette := make([]color.RGBA, 0, len(colourList))
+	if len(outPaletteTexture) == 0 {
+		for _, c := range colourList {
+			palette = append(palette, c.RGBA)
+		}
+		return palette, nil
+	}
+
+	width, height := getPaletteImageDimensions(len(colourList))
+	// NRGBA, not RGBA: colourList[n].RGBA is already the straight
+	// (non-premultiplied) colour (see buildAlphaAwareColourList), and
+	// image.RGBA.SetRGBA writes its argument's bytes straight into Pix
+	// without un-premultiplying first, so a color.RGBA-typed buffer here
+	// would silently darken every partially-transparent palette entry.
+	outPalette := image.NewNRGBA(image.Rect(0, 0, width, height))
+	x := 0
+	y := 0
+	for n := 0; n < len(colourList); n++ {
+		outPalette.SetNRGBA(x, y, color.NRGBA(colourList[n].RGBA))
+		palette = append(palette, colourList[n].RGBA)
+		x++
+		if x == width {
+			x = 0
+			y++
+		}
+	}
+
+	opf, err := os.OpenFile(outPaletteTexture, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer opf.Close()
+	if err := png.Encode(opf, outPalette); err != nil {
+		return nil, err
+	}
+
+	return palette, nil
+}
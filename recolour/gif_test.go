@@ -0,0 +1,104 @@
+package recolour
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// TestCompositeGIFFramesDisposal checks that compositeGIFFrames follows
+// gif.DisposalBackground the way a GIF player would: the disposed frame's
+// region is cleared to transparent before the next frame is drawn, rather
+// than left showing through underneath it.
+func TestCompositeGIFFramesDisposal(t *testing.T) {
+	pal := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{255, 255, 255, 255}}
+
+	// Frame 0: solid red, covering the whole logical screen.
+	frame0 := image.NewPaletted(image.Rect(0, 0, 4, 4), pal)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			frame0.SetColorIndex(x, y, 0)
+		}
+	}
+
+	// Frame 1: a white 2x2 square in the top-left corner, disposed to
+	// background afterwards.
+	frame1 := image.NewPaletted(image.Rect(0, 0, 2, 2), pal)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			frame1.SetColorIndex(x, y, 1)
+		}
+	}
+
+	// Frame 2: a single white pixel at the origin, leaving (1,1) -- inside
+	// frame 1's now-disposed region, but not redrawn by frame 2 -- to show
+	// whether the disposal actually took effect.
+	frame2 := image.NewPaletted(image.Rect(0, 0, 1, 1), pal)
+	frame2.SetColorIndex(0, 0, 1)
+
+	g := &gif.GIF{
+		Image:    []*image.Paletted{frame0, frame1, frame2},
+		Delay:    []int{0, 0, 0},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 4},
+	}
+
+	frames, _, err := compositeGIFFrames(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 composited frames, got %d", len(frames))
+	}
+
+	if c := frames[0].NRGBAAt(0, 0); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("frame0(0,0) = %v, want red", c)
+	}
+
+	if c := frames[1].NRGBAAt(0, 0); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("frame1(0,0) = %v, want white", c)
+	}
+	if c := frames[1].NRGBAAt(3, 3); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("frame1(3,3) = %v, want red", c)
+	}
+
+	if c := frames[2].NRGBAAt(0, 0); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("frame2(0,0) = %v, want white", c)
+	}
+	if c := frames[2].NRGBAAt(1, 1); c.A != 0 {
+		t.Errorf("frame2(1,1) = %v, want transparent (cleared by frame1's DisposalBackground)", c)
+	}
+}
+
+// TestGenerateFromGIFSharedPalette checks that GenerateFromGIF merges
+// colours across every frame into one shared palette rather than keying
+// off any single frame.
+func TestGenerateFromGIFSharedPalette(t *testing.T) {
+	pal := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+
+	frame0 := image.NewPaletted(image.Rect(0, 0, 2, 2), pal)
+	frame1 := image.NewPaletted(image.Rect(0, 0, 2, 2), pal)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			frame0.SetColorIndex(x, y, 0)
+			frame1.SetColorIndex(x, y, 1)
+		}
+	}
+
+	g := &gif.GIF{
+		Image:    []*image.Paletted{frame0, frame1},
+		Delay:    []int{0, 0},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone},
+		Config:   image.Config{Width: 2, Height: 2},
+	}
+
+	dir := t.TempDir()
+	got, err := GenerateFromGIF(g, dir+"/frame_%03d.png", "", GenerateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected a 2-colour shared palette, got %d", len(got))
+	}
+}
@@ -0,0 +1,409 @@
+package recolour
+
+import (
+	"math"
+	"sort"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+// SortMode selects how a UniqueColourList is ordered, which in turn decides
+// how palette indices are assigned (and therefore the values baked into the
+// reference sprite).
+type SortMode int
+
+const (
+	// SortHSV orders colours lexicographically by (H, S, V). This is the
+	// default: fast, but it produces visible discontinuities that make
+	// hand-editing the palette strip awkward.
+	SortHSV SortMode = iota
+	// SortPerceptual arranges colours so that consecutive palette entries
+	// are perceptually close: a greedy nearest-neighbour tour through CIE
+	// L*a*b* space (by CIEDE2000 distance), refined by a bounded 2-opt
+	// pass. Slower to compute, but much easier to eyeball and hand-edit.
+	SortPerceptual
+)
+
+// sortColourList orders colourList in place according to mode and
+// reassigns UniqueColour.Index so it matches the final position, the way
+// callers already expect after the original sort.Sort(colourList) call.
+func sortColourList(colourList UniqueColourList, mode SortMode) UniqueColourList {
+	if mode == SortPerceptual {
+		return orderPerceptual(colourList)
+	}
+
+	nextIndex := uint16(0)
+	for _, c := range colourList {
+		c.Index = nextIndex
+		nextIndex++
+	}
+	sort.Sort(colourList)
+	return colourList
+}
+
+// labPoint bundles a UniqueColour with its CIE L*a*b* coordinates, computed
+// once up front since both the kd-tree and the tour construction need it
+// repeatedly.
+type labPoint struct {
+	l, a, b float64
+	cf      colorful.Color
+	colour  *UniqueColour
+}
+
+func newLabPoint(c *UniqueColour) labPoint {
+	cf := colorful.Color{R: float64(c.RGBA.R) / 255.0, G: float64(c.RGBA.G) / 255.0, B: float64(c.RGBA.B) / 255.0}
+	l, a, b := cf.Lab()
+	return labPoint{l: l, a: a, b: b, cf: cf, colour: c}
+}
+
+func (p labPoint) euclid(q labPoint) float64 {
+	dl := p.l - q.l
+	da := p.a - q.a
+	db := p.b - q.b
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+func (p labPoint) ciede2000(q labPoint) float64 {
+	return p.cf.DistanceCIEDE2000(q.cf)
+}
+
+// orderPerceptual reorders colourList so consecutive entries are
+// perceptually close. Fully-transparent entries are pinned to index 0 and
+// excluded from the tour, since perceptual distance between invisible
+// pixels is meaningless.
+func orderPerceptual(colourList UniqueColourList) UniqueColourList {
+	if len(colourList) == 0 {
+		return colourList
+	}
+
+	result := make(UniqueColourList, 0, len(colourList))
+	nextIndex := uint16(0)
+
+	tourColours := make(UniqueColourList, 0, len(colourList))
+	for _, c := range colourList {
+		if c.RGBA.A == 0 {
+			c.Index = nextIndex
+			result = append(result, c)
+			nextIndex++
+			continue
+		}
+		tourColours = append(tourColours, c)
+	}
+
+	if len(tourColours) == 0 {
+		return result
+	}
+
+	points := make([]labPoint, len(tourColours))
+	for i, c := range tourColours {
+		points[i] = newLabPoint(c)
+	}
+
+	order := greedyNearestNeighbourTour(points)
+	order = twoOptImprove(points, order)
+
+	for _, idx := range order {
+		c := points[idx].colour
+		c.Index = nextIndex
+		result = append(result, c)
+		nextIndex++
+	}
+
+	return result
+}
+
+// greedyNearestNeighbourTour builds a visiting order starting from the
+// darkest point (lowest L*), then repeatedly appending the unvisited point
+// nearest (Euclidean distance in Lab space) to the last appended point.
+// Nearest-unvisited lookups go through a kd-tree with a live per-subtree
+// unvisited count, so a fully-visited subtree is skipped outright instead
+// of being walked again on every step; this keeps construction well below
+// the O(n^2) a naive linear scan would cost even at the 65536-colour
+// ceiling this package allows. Lab-Euclidean is an approximation of the
+// CIEDE2000 distance the rest of this file scores tours by (the same
+// approximation twoOptImprove's kd-tree neighbour lists already make), good
+// enough to seed a tour that 2-opt then refines against the real metric.
+func greedyNearestNeighbourTour(points []labPoint) []int {
+	n := len(points)
+	order := make([]int, 0, n)
+	if n == 0 {
+		return order
+	}
+
+	start := 0
+	for i := 1; i < n; i++ {
+		if points[i].l < points[start].l {
+			start = i
+		}
+	}
+
+	tree := buildKDTree(points)
+	order = append(order, start)
+	tree.markVisited(start)
+
+	for len(order) < n {
+		next := tree.nearestUnvisited(order[len(order)-1])
+		order = append(order, next)
+		tree.markVisited(next)
+	}
+	return order
+}
+
+// twoOptImprove runs a bounded 2-opt pass over order, reversing segments
+// when doing so lowers total path length (sum of consecutive CIEDE2000
+// distances; the path is linear, not a closed loop, since a palette strip
+// has a start and an end). For small n every pair is considered, capped at
+// a fixed iteration budget. For large n, candidate partners for each
+// position are restricted to its k=8 nearest neighbours in Lab space
+// (found via a kd-tree), since considering every pair would be O(n^2) per
+// pass and palettes can have up to 65536 entries.
+func twoOptImprove(points []labPoint, order []int) []int {
+	n := len(order)
+	if n < 4 {
+		return order
+	}
+
+	const maxIterations = 2_000_000
+	const largeN = 2000
+	const k = 8
+
+	pos := make([]int, n) // pos[pointIdx] = position in order
+	for i, idx := range order {
+		pos[idx] = i
+	}
+
+	dist := func(i, j int) float64 { return points[order[i]].ciede2000(points[order[j]]) }
+
+	tryImprove := func(i, j int) bool {
+		// Reversing order[i+1..j] replaces edges (i,i+1) and (j,j+1) with
+		// (i,j) and (i+1,j+1).
+		if j <= i+1 || j+1 >= n {
+			return false
+		}
+		before := dist(i, i+1) + dist(j, j+1)
+		after := dist(i, j) + dist(i+1, j+1)
+		if after >= before-EPSILON {
+			return false
+		}
+		for lo, hi := i+1, j; lo < hi; lo, hi = lo+1, hi-1 {
+			order[lo], order[hi] = order[hi], order[lo]
+		}
+		for p := i + 1; p <= j; p++ {
+			pos[order[p]] = p
+		}
+		return true
+	}
+
+	iterations := 0
+	if n <= largeN {
+		improved := true
+		for improved && iterations < maxIterations {
+			improved = false
+			for i := 0; i < n-1 && iterations < maxIterations; i++ {
+				for j := i + 2; j < n; j++ {
+					iterations++
+					if iterations >= maxIterations {
+						break
+					}
+					if tryImprove(i, j) {
+						improved = true
+					}
+				}
+			}
+		}
+		return order
+	}
+
+	// Large n: only compare against each point's k nearest Lab-space
+	// neighbours, found once via a kd-tree built over the (static) point
+	// coordinates.
+	tree := buildKDTree(points)
+	neighbours := make([][]int, len(points))
+	for i := range points {
+		neighbours[i] = tree.kNearest(i, k)
+	}
+
+	improved := true
+	for improved && iterations < maxIterations {
+		improved = false
+		for i := 0; i < n-1 && iterations < maxIterations; i++ {
+			for _, neighbourIdx := range neighbours[order[i]] {
+				j := pos[neighbourIdx]
+				lo, hi := i, j
+				if lo > hi {
+					lo, hi = hi, lo
+				}
+				iterations++
+				if iterations >= maxIterations {
+					break
+				}
+				if tryImprove(lo, hi) {
+					improved = true
+				}
+			}
+		}
+	}
+	return order
+}
+
+// kdTree is a static 3-D (L, a, b) k-d tree used to find each palette
+// entry's nearest perceptual neighbours for the bounded 2-opt pass, and
+// nearest-unvisited lookups for greedyNearestNeighbourTour.
+type kdTree struct {
+	points    []labPoint
+	root      *kdNode
+	nodeByIdx []*kdNode
+	visited   []bool
+}
+
+type kdNode struct {
+	idx                 int
+	left, right, parent *kdNode
+	// remaining is the count of not-yet-visited points in this node's
+	// subtree (including itself). nearestUnvisited prunes any subtree whose
+	// remaining has dropped to zero instead of walking into it again.
+	remaining int
+}
+
+func buildKDTree(points []labPoint) *kdTree {
+	idxs := make([]int, len(points))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	t := &kdTree{points: points, nodeByIdx: make([]*kdNode, len(points)), visited: make([]bool, len(points))}
+	t.root, _ = t.build(idxs, 0, nil)
+	return t
+}
+
+func (t *kdTree) axisValue(idx, axis int) float64 {
+	switch axis {
+	case 0:
+		return t.points[idx].l
+	case 1:
+		return t.points[idx].a
+	default:
+		return t.points[idx].b
+	}
+}
+
+// build returns the constructed subtree alongside its size, so the caller
+// (building the parent) can set up its own remaining count.
+func (t *kdTree) build(idxs []int, depth int, parent *kdNode) (*kdNode, int) {
+	if len(idxs) == 0 {
+		return nil, 0
+	}
+	axis := depth % 3
+	sort.Slice(idxs, func(i, j int) bool { return t.axisValue(idxs[i], axis) < t.axisValue(idxs[j], axis) })
+	mid := len(idxs) / 2
+	node := &kdNode{idx: idxs[mid], parent: parent}
+	t.nodeByIdx[node.idx] = node
+
+	var leftSize, rightSize int
+	node.left, leftSize = t.build(idxs[:mid], depth+1, node)
+	node.right, rightSize = t.build(idxs[mid+1:], depth+1, node)
+	node.remaining = 1 + leftSize + rightSize
+	return node, node.remaining
+}
+
+// markVisited records idx as visited and decrements remaining along its
+// path to the root, so later nearestUnvisited calls can prune subtrees that
+// are now fully visited.
+func (t *kdTree) markVisited(idx int) {
+	t.visited[idx] = true
+	for n := t.nodeByIdx[idx]; n != nil; n = n.parent {
+		n.remaining--
+	}
+}
+
+// nearestUnvisited returns the not-yet-visited point (Euclidean distance in
+// Lab space) nearest to t.points[target], or -1 if every point has been
+// visited.
+func (t *kdTree) nearestUnvisited(target int) int {
+	best := -1
+	bestDist := math.MaxFloat64
+	targetPoint := t.points[target]
+
+	var visit func(n *kdNode, depth int)
+	visit = func(n *kdNode, depth int) {
+		if n == nil || n.remaining == 0 {
+			return
+		}
+		if !t.visited[n.idx] {
+			if d := targetPoint.euclid(t.points[n.idx]); d < bestDist {
+				bestDist = d
+				best = n.idx
+			}
+		}
+
+		axis := depth % 3
+		diff := t.axisValue(target, axis) - t.axisValue(n.idx, axis)
+
+		near, far := n.left, n.right
+		if diff > 0 {
+			near, far = n.right, n.left
+		}
+		visit(near, depth+1)
+		if math.Abs(diff) < bestDist {
+			visit(far, depth+1)
+		}
+	}
+	visit(t.root, 0)
+	return best
+}
+
+// kNearest returns up to k indices (into t.points) nearest to
+// t.points[target] by Euclidean distance in Lab space, excluding target.
+func (t *kdTree) kNearest(target, k int) []int {
+	type cand struct {
+		idx int
+		d   float64
+	}
+	best := make([]cand, 0, k)
+
+	worst := func() float64 {
+		if len(best) < k {
+			return math.MaxFloat64
+		}
+		return best[len(best)-1].d
+	}
+	insert := func(idx int, d float64) {
+		if len(best) < k {
+			best = append(best, cand{idx, d})
+		} else if d < best[len(best)-1].d {
+			best[len(best)-1] = cand{idx, d}
+		} else {
+			return
+		}
+		sort.Slice(best, func(i, j int) bool { return best[i].d < best[j].d })
+	}
+
+	target_ := t.points[target]
+	var visit func(n *kdNode, depth int)
+	visit = func(n *kdNode, depth int) {
+		if n == nil {
+			return
+		}
+		if n.idx != target {
+			insert(n.idx, target_.euclid(t.points[n.idx]))
+		}
+
+		axis := depth % 3
+		diff := t.axisValue(target, axis) - t.axisValue(n.idx, axis)
+
+		near, far := n.left, n.right
+		if diff > 0 {
+			near, far = n.right, n.left
+		}
+		visit(near, depth+1)
+		if math.Abs(diff) < worst() {
+			visit(far, depth+1)
+		}
+	}
+	visit(t.root, 0)
+
+	out := make([]int, len(best))
+	for i, c := range best {
+		out[i] = c.idx
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package recolour
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+// capOrQuantizeColours enforces the 65536-colour ceiling that the RGBA
+// reference encoding imposes (its index is split across two 8-bit
+// channels). When maxColors is zero it behaves exactly as before:
+// colourMap is returned unchanged, or an error if it already exceeds the
+// ceiling. When maxColors is set, exceeding it quantizes the palette down
+// to maxColors representative colours instead of failing.
+func capOrQuantizeColours(colourMap map[color.RGBA]*UniqueColour, maxColors int) (map[color.RGBA]*UniqueColour, error) {
+	if maxColors <= 0 {
+		if len(colourMap) > 65536 {
+			return nil, fmt.Errorf("Sorry, sprite contains too many colours")
+		}
+		return colourMap, nil
+	}
+
+	if maxColors > 65536 {
+		return nil, fmt.Errorf("MaxColors must be <= 65536, got %d", maxColors)
+	}
+
+	if len(colourMap) <= maxColors {
+		return colourMap, nil
+	}
+
+	return quantizeColours(colourMap, maxColors), nil
+}
+
+// capOrQuantizeAlphaColours is capOrQuantizeColours for FormatPaletted's
+// alpha-aware colour map: the hard ceiling is the PNG PLTE format's 256
+// entries rather than the RGBA reference's 65536, but MaxColors quantizes
+// down the same way, via the same Lab-space median-cut (alpha just rides
+// along as part of each UniqueColour's RGBA, weighted into the bucket mean
+// like any other channel).
+func capOrQuantizeAlphaColours(colourMap map[color.RGBA]*UniqueColour, maxColors int) (map[color.RGBA]*UniqueColour, error) {
+	if maxColors <= 0 {
+		if len(colourMap) > 256 {
+			return nil, fmt.Errorf("Sorry, sprite contains too many colours for paletted PNG output (%d, max 256)", len(colourMap))
+		}
+		return colourMap, nil
+	}
+
+	if maxColors > 256 {
+		return nil, fmt.Errorf("MaxColors must be <= 256 for paletted output, got %d", maxColors)
+	}
+
+	if len(colourMap) <= maxColors {
+		return colourMap, nil
+	}
+
+	return quantizeColours(colourMap, maxColors), nil
+}
+
+// quantizeColours reduces colourMap to at most maxColors representative
+// colours using median-cut in CIE L*a*b* space: starting from one bucket
+// holding every colour, it repeatedly splits the bucket with the greatest
+// variance along that bucket's own widest axis, taking the (unweighted)
+// median as the split point, until maxColors buckets exist. Each bucket's
+// representative colour is then the mean of its members' RGB, weighted by
+// how many source pixels (UniqueColour.Count) each member was seen at.
+//
+// It returns a new map with the SAME keys as colourMap, but where every
+// key's value now points at its bucket's shared representative
+// UniqueColour. This lets callers keep using colourMap exactly as before
+// (e.g. reference-sprite emit loops do colourMap[pixel] for an O(1) lookup
+// with no per-pixel palette search) while getting a palette of
+// len(distinct values) <= maxColors once fed through
+// buildSortedColourList, which already dedupes by pointer identity.
+func quantizeColours(colourMap map[color.RGBA]*UniqueColour, maxColors int) map[color.RGBA]*UniqueColour {
+	points := make([]quantizePoint, 0, len(colourMap))
+	for key, c := range colourMap {
+		cf := colorful.Color{R: float64(c.RGBA.R) / 255.0, G: float64(c.RGBA.G) / 255.0, B: float64(c.RGBA.B) / 255.0}
+		l, a, b := cf.Lab()
+		points = append(points, quantizePoint{key: key, colour: c, l: l, a: a, b: b})
+	}
+
+	buckets := medianCutBuckets(points, maxColors)
+
+	result := make(map[color.RGBA]*UniqueColour, len(colourMap))
+	for _, members := range buckets {
+		rep := bucketMean(points, members)
+		for _, idx := range members {
+			result[points[idx].key] = rep
+		}
+	}
+	return result
+}
+
+// quantizePoint is a colourMap entry's position in Lab space, alongside its
+// original map key (which, for the alpha-aware map, differs from
+// colour.RGBA since UniqueColour.RGBA holds the straight colour while the
+// key is colourTo8BitRGBA's premultiplied form) and the UniqueColour itself
+// (which carries the pixel count needed to weight the eventual bucket
+// mean).
+type quantizePoint struct {
+	l, a, b float64
+	key     color.RGBA
+	colour  *UniqueColour
+}
+
+func (p quantizePoint) axis(axis int) float64 {
+	switch axis {
+	case 0:
+		return p.l
+	case 1:
+		return p.a
+	default:
+		return p.b
+	}
+}
+
+// medianCutBuckets partitions points (by index) into at most maxColors
+// buckets via median-cut.
+func medianCutBuckets(points []quantizePoint, maxColors int) [][]int {
+	all := make([]int, len(points))
+	for i := range all {
+		all[i] = i
+	}
+	buckets := [][]int{all}
+
+	for len(buckets) < maxColors {
+		splitIdx, axis := widestBucket(points, buckets)
+		if splitIdx == -1 {
+			break // every remaining bucket has a single unique colour
+		}
+
+		members := buckets[splitIdx]
+		sort.Slice(members, func(i, j int) bool {
+			return points[members[i]].axis(axis) < points[members[j]].axis(axis)
+		})
+		mid := len(members) / 2
+		left := append([]int(nil), members[:mid]...)
+		right := append([]int(nil), members[mid:]...)
+
+		buckets[splitIdx] = left
+		buckets = append(buckets, right)
+	}
+
+	return buckets
+}
+
+// widestBucket finds the bucket with the greatest total variance across
+// its three Lab axes (the one most worth splitting next) and returns its
+// index plus the axis (0=L, 1=a, 2=b) that axis-contributes most of that
+// variance. Returns splitIdx -1 if no bucket can be split further (every
+// bucket holds a single distinct point, or is already a singleton).
+func widestBucket(points []quantizePoint, buckets [][]int) (splitIdx, axis int) {
+	splitIdx = -1
+	var bestVariance float64
+	for i, members := range buckets {
+		if len(members) < 2 {
+			continue
+		}
+		varL, varA, varB := bucketVariance(points, members)
+		total := varL + varA + varB
+		if total <= EPSILON {
+			continue // every member is the same colour; can't usefully split
+		}
+		if splitIdx == -1 || total > bestVariance {
+			splitIdx = i
+			bestVariance = total
+			switch {
+			case varL >= varA && varL >= varB:
+				axis = 0
+			case varA >= varB:
+				axis = 1
+			default:
+				axis = 2
+			}
+		}
+	}
+	return splitIdx, axis
+}
+
+func bucketVariance(points []quantizePoint, members []int) (varL, varA, varB float64) {
+	var meanL, meanA, meanB float64
+	for _, idx := range members {
+		p := points[idx]
+		meanL += p.l
+		meanA += p.a
+		meanB += p.b
+	}
+	n := float64(len(members))
+	meanL /= n
+	meanA /= n
+	meanB /= n
+
+	for _, idx := range members {
+		p := points[idx]
+		dl := p.l - meanL
+		da := p.a - meanA
+		db := p.b - meanB
+		varL += dl * dl
+		varA += da * da
+		varB += db * db
+	}
+	return varL / n, varA / n, varB / n
+}
+
+// bucketMean builds the representative UniqueColour for a bucket: the mean
+// of its members' RGB (and alpha), weighted by pixel count.
+func bucketMean(points []quantizePoint, members []int) *UniqueColour {
+	var sumR, sumG, sumB, sumA, totalCount float64
+	for _, idx := range members {
+		c := points[idx].colour
+		weight := float64(c.Count)
+		if weight <= 0 {
+			weight = 1
+		}
+		sumR += float64(c.RGBA.R) * weight
+		sumG += float64(c.RGBA.G) * weight
+		sumB += float64(c.RGBA.B) * weight
+		sumA += float64(c.RGBA.A) * weight
+		totalCount += weight
+	}
+
+	rep := color.RGBA{
+		R: uint8(sumR / totalCount),
+		G: uint8(sumG / totalCount),
+		B: uint8(sumB / totalCount),
+		A: uint8(sumA / totalCount),
+	}
+	cf := colorful.Color{R: float64(rep.R) / 255.0, G: float64(rep.G) / 255.0, B: float64(rep.B) / 255.0}
+	h, s, v := cf.Hsv()
+
+	var count int
+	for _, idx := range members {
+		count += points[idx].colour.Count
+	}
+
+	return &UniqueColour{RGBA: rep, H: h, S: s, V: v, Count: count}
+}
@@ -0,0 +1,118 @@
+package recolour
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func distinctRepresentatives(colourMap map[color.RGBA]*UniqueColour) int {
+	seen := make(map[*UniqueColour]bool, len(colourMap))
+	for _, c := range colourMap {
+		seen[c] = true
+	}
+	return len(seen)
+}
+
+func TestCapOrQuantizeColoursReducesToMaxColors(t *testing.T) {
+	colourMap := make(map[color.RGBA]*UniqueColour)
+	for i := 0; i < 50; i++ {
+		c := color.RGBA{R: uint8(i * 5), A: 255}
+		colourMap[c] = &UniqueColour{RGBA: c, Count: 1}
+	}
+
+	reduced, err := capOrQuantizeColours(colourMap, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reduced) != 50 {
+		t.Fatalf("quantizeColours should keep every original key, got %d", len(reduced))
+	}
+	if n := distinctRepresentatives(reduced); n > 10 {
+		t.Fatalf("expected at most 10 distinct representative colours, got %d", n)
+	}
+}
+
+func TestCapOrQuantizeColoursPassesThroughUnderLimit(t *testing.T) {
+	colourMap := make(map[color.RGBA]*UniqueColour)
+	for i := 0; i < 5; i++ {
+		c := color.RGBA{R: uint8(i * 40), A: 255}
+		colourMap[c] = &UniqueColour{RGBA: c, Count: 1}
+	}
+
+	reduced, err := capOrQuantizeColours(colourMap, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reduced) != 5 {
+		t.Fatalf("expected passthrough when already under MaxColors, got %d entries", len(reduced))
+	}
+	for c, v := range colourMap {
+		if reduced[c] != v {
+			t.Fatalf("expected unchanged map when under MaxColors")
+		}
+	}
+}
+
+func TestCapOrQuantizeAlphaColoursErrorsAboveCeilingWithoutMaxColors(t *testing.T) {
+	colourMap := make(map[color.RGBA]*UniqueColour)
+	for i := 0; i < 300; i++ {
+		c := color.RGBA{R: uint8(i % 256), G: uint8(i / 256), A: 255}
+		colourMap[c] = &UniqueColour{RGBA: c, Count: 1}
+	}
+
+	if _, err := capOrQuantizeAlphaColours(colourMap, 0); err == nil {
+		t.Fatal("expected an error when over the 256-entry ceiling with MaxColors unset")
+	}
+
+	reduced, err := capOrQuantizeAlphaColours(colourMap, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := distinctRepresentatives(reduced); n > 200 {
+		t.Fatalf("expected at most 200 distinct representative colours, got %d", n)
+	}
+}
+
+// TestQuantizeColoursWeightsByCount checks that a bucket's representative
+// colour is pulled towards whichever member was seen at more pixels, not a
+// plain unweighted average.
+func TestQuantizeColoursWeightsByCount(t *testing.T) {
+	colourMap := map[color.RGBA]*UniqueColour{
+		{R: 0, A: 255}:   {RGBA: color.RGBA{R: 0, A: 255}, Count: 1000},
+		{R: 100, A: 255}: {RGBA: color.RGBA{R: 100, A: 255}, Count: 1},
+	}
+
+	reduced := quantizeColours(colourMap, 1)
+	rep := reduced[color.RGBA{R: 0, A: 255}]
+	if reduced[color.RGBA{R: 100, A: 255}] != rep {
+		t.Fatal("expected both keys to share one representative when reduced to 1 colour")
+	}
+	if rep.RGBA.R > 50 {
+		t.Fatalf("expected the representative to be weighted towards the heavier colour (R near 0), got R=%d", rep.RGBA.R)
+	}
+}
+
+// TestBuildAlphaAwareColourListTracksCount checks that the alpha-aware scan
+// (FormatPaletted's path into quantization) tracks per-colour pixel counts
+// the same way scanUniqueColours does, since capOrQuantizeAlphaColours'
+// bucket means are weighted by UniqueColour.Count -- if it stayed zero,
+// bucketMean's weight<=0 fallback would silently turn that into a plain
+// unweighted average.
+func TestBuildAlphaAwareColourListTracksCount(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 255})
+	img.SetNRGBA(2, 0, color.NRGBA{100, 0, 0, 255})
+
+	colourMap, _, err := buildAlphaAwareColourList(img, img.Bounds(), SortHSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := colourMap[color.RGBA{R: 0, A: 255}].Count; got != 2 {
+		t.Fatalf("expected Count 2 for the repeated colour, got %d", got)
+	}
+	if got := colourMap[color.RGBA{R: 100, A: 255}].Count; got != 1 {
+		t.Fatalf("expected Count 1 for the single-pixel colour, got %d", got)
+	}
+}
@@ -0,0 +1,69 @@
+package recolour
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// benchPalette is a small, fixed set of colours used to fill benchmark
+// images. Sprite atlases are large in pixels but low in distinct colours,
+// which is exactly the case this package targets (and the only case it
+// can handle at all without MaxColors quantization, given the 65536
+// colour ceiling).
+var benchPalette = []color.NRGBA{
+	{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255},
+	{0, 255, 255, 255}, {255, 0, 255, 255}, {255, 255, 255, 255}, {0, 0, 0, 0},
+	{128, 64, 32, 255}, {16, 200, 100, 128},
+}
+
+func makeBenchImage(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	rnd := rand.New(rand.NewSource(int64(size)))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetNRGBA(x, y, benchPalette[rnd.Intn(len(benchPalette))])
+		}
+	}
+	return img
+}
+
+func benchmarkGenerate(b *testing.B, size int) {
+	img := makeBenchImage(size)
+	dir := b.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GenerateFromImage(img, refPath, palPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkGenerateStreaming(b *testing.B, size int) {
+	img := makeBenchImage(size)
+	dir := b.TempDir()
+	refPath := filepath.Join(dir, "ref.png")
+	palPath := filepath.Join(dir, "pal.png")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GenerateFromImageStreaming(img, refPath, palPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerate_512(b *testing.B)  { benchmarkGenerate(b, 512) }
+func BenchmarkGenerate_2048(b *testing.B) { benchmarkGenerate(b, 2048) }
+func BenchmarkGenerate_8192(b *testing.B) { benchmarkGenerate(b, 8192) }
+
+func BenchmarkGenerateStreaming_512(b *testing.B)  { benchmarkGenerateStreaming(b, 512) }
+func BenchmarkGenerateStreaming_2048(b *testing.B) { benchmarkGenerateStreaming(b, 2048) }
+func BenchmarkGenerateStreaming_8192(b *testing.B) { benchmarkGenerateStreaming(b, 8192) }
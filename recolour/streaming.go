@@ -0,0 +1,164 @@
+package recolour
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"sort"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+// streamingMaxCount bounds the occurrence count packed into a streaming
+// colour entry (see packEntry). Counts that saturate past this only affect
+// palette ordering as a weighting hint, so clamping is harmless.
+const streamingMaxCount = 0xFFFF
+
+// packEntry packs an occurrence count (saturating at streamingMaxCount)
+// and a palette index into a single uint32: count in the high 16 bits,
+// index in the low 16. This lets GenerateFromImageStreaming track colours
+// in a value-typed map[color.RGBA]uint32 instead of one *UniqueColour
+// allocation per distinct colour.
+func packEntry(count uint32, index uint16) uint32 {
+	if count > streamingMaxCount {
+		count = streamingMaxCount
+	}
+	return count<<16 | uint32(index)
+}
+
+func unpackCount(entry uint32) uint16 { return uint16(entry >> 16) }
+func unpackIndex(entry uint32) uint16 { return uint16(entry) }
+
+// streamingTileHeight is how many rows GenerateFromImageStreaming scans
+// per tile. Every row is visited exactly once either way; tiling keeps
+// each pass's working set small enough to stay cache-resident on very
+// large atlases rather than changing the algorithmic complexity.
+const streamingTileHeight = 64
+
+// GenerateFromImageStreaming is GenerateFromImage's memory-conscious
+// sibling for very large sprite atlases. The default path holds a full
+// image.NRGBA output buffer and a map[color.RGBA]*UniqueColour (one
+// pointer-sized allocation per distinct colour) in memory at once; this
+// path instead:
+//
+//   - tracks colours in a value-typed map[color.RGBA]uint32 (occurrence
+//     count packed into the high 16 bits, palette index into the low 16),
+//   - scans the image in horizontal tiles for better cache locality,
+//   - and writes the reference sprite by handing png.Encode a lazily
+//     computed image.Image, so no second full-size buffer is allocated.
+//
+// It only supports the default FormatRGBA encoding with HSV palette
+// ordering; use GenerateFromImageWithOptions for paletted output,
+// perceptual sorting, or quantization.
+func GenerateFromImageStreaming(img image.Image, outImagePath, outPaletteTexture string) ([]color.RGBA, error) {
+	bounds := img.Bounds()
+
+	counts := make(map[color.RGBA]uint32)
+	// representative holds, per grouping key, the true (non-premultiplied)
+	// colour of the first pixel that produced it. The key itself can't be
+	// used for this: colourTo8BitPaletteRGBA's R/G/B come straight out of
+	// the alpha-premultiplied color.Color.RGBA(), only forced opaque
+	// afterwards, so for a partially-transparent source pixel the key is
+	// that pixel's colour scaled down by its own alpha, not the real colour.
+	representative := make(map[color.RGBA]color.RGBA)
+	for tileTop := bounds.Min.Y; tileTop < bounds.Max.Y; tileTop += streamingTileHeight {
+		tileBottom := tileTop + streamingTileHeight
+		if tileBottom > bounds.Max.Y {
+			tileBottom = bounds.Max.Y
+		}
+		for y := tileTop; y < tileBottom; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				src := img.At(x, y)
+				p := colourTo8BitPaletteRGBA(src)
+				if _, ok := counts[p]; !ok {
+					straight := color.NRGBAModel.Convert(colourTo8BitRGBA(src)).(color.NRGBA)
+					representative[p] = color.RGBA{R: straight.R, G: straight.G, B: straight.B, A: 255}
+				}
+				counts[p] = packEntry(uint32(unpackCount(counts[p]))+1, 0)
+			}
+		}
+	}
+
+	if len(counts) > 65536 {
+		return nil, fmt.Errorf("Sorry, sprite contains too many colours")
+	}
+
+	keys := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool { return hsvLess(keys[i], keys[j]) })
+	palette := make([]color.RGBA, len(keys))
+	for i, k := range keys {
+		counts[k] = packEntry(uint32(unpackCount(counts[k])), uint16(i))
+		palette[i] = representative[k]
+	}
+
+	of, err := os.OpenFile(outImagePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer of.Close()
+
+	refImage := &streamingReferenceImage{src: img, bounds: bounds, counts: counts}
+	if err := png.Encode(of, refImage); err != nil {
+		return nil, err
+	}
+
+	// Palette-texture writing only allocates one UniqueColour per palette
+	// entry (bounded by the 65536-colour ceiling, not by image size), so
+	// there's no need for a streaming variant of writePaletteTexture too.
+	colourList := make(UniqueColourList, len(palette))
+	for i, c := range palette {
+		colourList[i] = &UniqueColour{RGBA: c, Index: uint16(i)}
+	}
+	return writePaletteTexture(colourList, outPaletteTexture)
+}
+
+// hsvLess orders two solid colours the same way UniqueColourList's default
+// sort does, without needing a *UniqueColour per comparison.
+func hsvLess(a, b color.RGBA) bool {
+	ah, as, av := colourHSV(a)
+	bh, bs, bv := colourHSV(b)
+	if floatEquals(ah, bh) {
+		if floatEquals(as, bs) {
+			return av < bv
+		}
+		return as < bs
+	}
+	return ah < bh
+}
+
+func colourHSV(c color.RGBA) (h, s, v float64) {
+	cf := colorful.Color{R: float64(c.R) / 255.0, G: float64(c.G) / 255.0, B: float64(c.B) / 255.0}
+	return cf.Hsv()
+}
+
+// streamingReferenceImage computes each reference-sprite pixel on demand
+// from the source image and the packed colour map, rather than
+// materializing a second full-size output buffer up front.
+type streamingReferenceImage struct {
+	src    image.Image
+	bounds image.Rectangle
+	counts map[color.RGBA]uint32
+}
+
+func (r *streamingReferenceImage) ColorModel() color.Model { return color.NRGBAModel }
+func (r *streamingReferenceImage) Bounds() image.Rectangle { return r.bounds }
+
+func (r *streamingReferenceImage) At(x, y int) color.Color {
+	src := r.src.At(x, y)
+	inpix := colourTo8BitPaletteRGBA(src)
+	entry, ok := r.counts[inpix]
+	if !ok {
+		return color.NRGBA{}
+	}
+
+	idx := unpackIndex(entry)
+	red := uint8(idx & 0x00FF)
+	blue := uint8(idx >> 8)
+	alpha := colourTo8BitRGBA(src).A
+	return color.NRGBA{R: red, G: blue, B: 0, A: alpha}
+}